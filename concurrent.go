@@ -0,0 +1,261 @@
+package ps
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentMap is a hash trie, shaped like the persistent Map's HAMT, but
+// whose child slots are atomic.Pointer values so a single map handle can be
+// mutated concurrently by many goroutines: Load walks child pointers
+// without taking any lock, while Store/LoadOrStore/CompareAndSwap/Delete
+// CAS the leaf slot at the terminating level, retrying on conflict. The
+// value type must be comparable so CompareAndSwap can compare it directly,
+// unlike the persistent Map which only requires V any.
+//
+// Unlike Map, ConcurrentMap isn't persistent: mutating it changes what
+// every holder of the handle sees. Snapshot bridges the two worlds by
+// copying the current contents into an immutable Map.
+type ConcurrentMap[K comparable, V comparable] struct {
+	root concurrentNode[K, V]
+}
+
+// NewConcurrentMap allocates a new, empty ConcurrentMap safe for
+// concurrent use by multiple goroutines.
+func NewConcurrentMap[K comparable, V comparable]() *ConcurrentMap[K, V] {
+	return &ConcurrentMap[K, V]{}
+}
+
+// concurrentNode is one level of the trie: up to 1<<bitsPerLevel child
+// slots, indexed the same way as the persistent Map's branch nodes. mu
+// serializes the one structural change a concurrentNode ever needs: turning
+// a leaf slot into a child concurrentNode when a second, differently-hashed
+// key lands in the same slot.
+type concurrentNode[K comparable, V comparable] struct {
+	mu       sync.Mutex
+	children [1 << bitsPerLevel]atomic.Pointer[concurrentSlot[K, V]]
+}
+
+// concurrentSlot is the value behind a child pointer: either a further
+// concurrentNode to descend into, or the leaf(s) hashing into this slot.
+// leaves holds more than one entry only for keys sharing the same full
+// hash, which leaves no bits to branch on; such keys share one slot at
+// whatever level they first meet.
+type concurrentSlot[K comparable, V comparable] struct {
+	node   *concurrentNode[K, V]
+	leaves []concurrentLeaf[K, V]
+}
+
+type concurrentLeaf[K comparable, V comparable] struct {
+	hash  uint64
+	key   K
+	value V
+}
+
+// Load returns the value stored for key, if any. It never blocks on mu -
+// only structural writers do.
+func (m *ConcurrentMap[K, V]) Load(key K) (V, bool) {
+	hash := hashAny(key)
+	n := &m.root
+	for level := 0; ; level++ {
+		slot := n.children[hashChunk(hash, level)].Load()
+		if slot == nil {
+			var zero V
+			return zero, false
+		}
+		if slot.node != nil {
+			n = slot.node
+			continue
+		}
+		for _, l := range slot.leaves {
+			if l.key == key {
+				return l.value, true
+			}
+		}
+		var zero V
+		return zero, false
+	}
+}
+
+// Store sets the value associated with key, creating the association if
+// it didn't already exist.
+func (m *ConcurrentMap[K, V]) Store(key K, value V) {
+	hash := hashAny(key)
+	m.upsert(hash, key, func(leaves []concurrentLeaf[K, V]) ([]concurrentLeaf[K, V], bool) {
+		return upsertLeaf(leaves, hash, key, value), true
+	})
+}
+
+// LoadOrStore returns the existing value for key if present; otherwise it
+// stores and returns value. loaded reports which happened.
+func (m *ConcurrentMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	hash := hashAny(key)
+	m.upsert(hash, key, func(leaves []concurrentLeaf[K, V]) ([]concurrentLeaf[K, V], bool) {
+		for _, l := range leaves {
+			if l.key == key {
+				actual, loaded = l.value, true
+				return leaves, false
+			}
+		}
+		actual = value
+		return upsertLeaf(leaves, hash, key, value), true
+	})
+	return actual, loaded
+}
+
+// CompareAndSwap sets the value for key to new only if it's currently old,
+// reporting whether the swap happened. It reports false if key isn't
+// present.
+func (m *ConcurrentMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	hash := hashAny(key)
+	swapped := false
+	m.upsert(hash, key, func(leaves []concurrentLeaf[K, V]) ([]concurrentLeaf[K, V], bool) {
+		for i, l := range leaves {
+			if l.key != key {
+				continue
+			}
+			if l.value != old {
+				return leaves, false
+			}
+			out := append([]concurrentLeaf[K, V]{}, leaves...)
+			out[i] = concurrentLeaf[K, V]{hash: hash, key: key, value: new}
+			swapped = true
+			return out, true
+		}
+		return leaves, false
+	})
+	return swapped
+}
+
+// Delete removes key, reporting whether it was present.
+func (m *ConcurrentMap[K, V]) Delete(key K) bool {
+	hash := hashAny(key)
+	deleted := false
+	m.upsert(hash, key, func(leaves []concurrentLeaf[K, V]) ([]concurrentLeaf[K, V], bool) {
+		for i, l := range leaves {
+			if l.key == key {
+				out := append(append([]concurrentLeaf[K, V]{}, leaves[:i]...), leaves[i+1:]...)
+				deleted = true
+				return out, true
+			}
+		}
+		return leaves, false
+	})
+	return deleted
+}
+
+// Snapshot copies the ConcurrentMap's current contents into an immutable
+// Map, usable with the rest of the persistent API - e.g. to hand a
+// background worker a stable view while other goroutines keep mutating the
+// original. The copy is taken slot by slot without pausing writers, so a
+// write that runs concurrently with Snapshot may or may not appear in it:
+// it is not a single point-in-time view of a map that is being mutated.
+func (m *ConcurrentMap[K, V]) Snapshot() Map[K, V] {
+	result := NewMap[K, V]()
+	walkConcurrentNode(&m.root, func(key K, value V) {
+		result = result.UnsafeMutableSet(key, value)
+	})
+	return result
+}
+
+func walkConcurrentNode[K comparable, V comparable](n *concurrentNode[K, V], f func(K, V)) {
+	for i := range n.children {
+		slot := n.children[i].Load()
+		if slot == nil {
+			continue
+		}
+		if slot.node != nil {
+			walkConcurrentNode(slot.node, f)
+			continue
+		}
+		for _, l := range slot.leaves {
+			f(l.key, l.value)
+		}
+	}
+}
+
+// upsertLeaf inserts or replaces key's entry in leaves, copying rather than
+// mutating since leaves is always reachable from a published slot.
+func upsertLeaf[K comparable, V comparable](leaves []concurrentLeaf[K, V], hash uint64, key K, value V) []concurrentLeaf[K, V] {
+	for i, l := range leaves {
+		if l.key == key {
+			out := append([]concurrentLeaf[K, V]{}, leaves...)
+			out[i] = concurrentLeaf[K, V]{hash: hash, key: key, value: value}
+			return out
+		}
+	}
+	return append(append([]concurrentLeaf[K, V]{}, leaves...), concurrentLeaf[K, V]{hash: hash, key: key, value: value})
+}
+
+// upsert walks the trie to the slot for hash/key, calling update with that
+// slot's current leaves (nil if the slot is empty) and CASing in the
+// result if update reports a change. It retries whenever a CAS loses a
+// race against a concurrent writer, and expands a leaf slot into a child
+// node - serialized by the parent's mutex - when a new key's hash diverges
+// from what's already there.
+func (m *ConcurrentMap[K, V]) upsert(hash uint64, key K, update func(leaves []concurrentLeaf[K, V]) (newLeaves []concurrentLeaf[K, V], changed bool)) {
+	n := &m.root
+	level := 0
+	for {
+		idx := hashChunk(hash, level)
+		old := n.children[idx].Load()
+
+		switch {
+		case old == nil:
+			newLeaves, changed := update(nil)
+			if !changed {
+				return
+			}
+			if n.children[idx].CompareAndSwap(nil, &concurrentSlot[K, V]{leaves: newLeaves}) {
+				return
+			}
+			continue // lost the race to another writer; retry this level
+
+		case old.node != nil:
+			n = old.node
+			level++
+			continue
+
+		case level >= maxLevel || (len(old.leaves) > 0 && old.leaves[0].hash == hash):
+			newLeaves, changed := update(old.leaves)
+			if !changed {
+				return
+			}
+			if n.children[idx].CompareAndSwap(old, &concurrentSlot[K, V]{leaves: newLeaves}) {
+				return
+			}
+			continue
+
+		default:
+			// A different hash wants this slot: split it into a child
+			// node so both hashes get their own slot one level down. mu
+			// only serializes other splitters; a plain Store/Delete CAS
+			// elsewhere doesn't take it, so the split must still commit
+			// via CompareAndSwap(old, ...) and retry on failure, the same
+			// as every other branch here - otherwise a write that lands
+			// between the mu check and the commit would be silently
+			// discarded by a Store built from the now-stale old.leaves.
+			n.mu.Lock()
+			if cur := n.children[idx].Load(); cur != old {
+				n.mu.Unlock()
+				continue // slot changed under us; retry with fresh state
+			}
+			child := &concurrentNode[K, V]{}
+			for _, l := range old.leaves {
+				childIdx := hashChunk(l.hash, level+1)
+				var leaves []concurrentLeaf[K, V]
+				if s := child.children[childIdx].Load(); s != nil {
+					leaves = s.leaves // same-hash leaves stay together
+				}
+				child.children[childIdx].Store(&concurrentSlot[K, V]{leaves: append(leaves, l)})
+			}
+			committed := n.children[idx].CompareAndSwap(old, &concurrentSlot[K, V]{node: child})
+			n.mu.Unlock()
+			if !committed {
+				continue // lost the race to a plain writer; retry with fresh state
+			}
+			n = child
+			level++
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ps
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentMapLoadStoreDelete(t *testing.T) {
+	m := NewConcurrentMap[string, int]()
+
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load on an empty map should report absent")
+	}
+
+	m.Store("a", 1)
+	if v, ok := m.Load("a"); !ok || v != 1 {
+		t.Fatalf("Load(a) = %v, %v, want 1, true", v, ok)
+	}
+
+	m.Store("a", 2)
+	if v, ok := m.Load("a"); !ok || v != 2 {
+		t.Fatalf("Load(a) = %v, %v after overwrite, want 2, true", v, ok)
+	}
+
+	if !m.Delete("a") {
+		t.Fatal("Delete(a) should report true")
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("Load(a) should report absent after Delete")
+	}
+	if m.Delete("a") {
+		t.Fatal("Delete(a) should report false when already absent")
+	}
+}
+
+func TestConcurrentMapLoadOrStore(t *testing.T) {
+	m := NewConcurrentMap[string, int]()
+
+	actual, loaded := m.LoadOrStore("a", 1)
+	if loaded || actual != 1 {
+		t.Fatalf("LoadOrStore(a, 1) = %v, %v, want 1, false", actual, loaded)
+	}
+
+	actual, loaded = m.LoadOrStore("a", 2)
+	if !loaded || actual != 1 {
+		t.Fatalf("LoadOrStore(a, 2) = %v, %v, want 1, true", actual, loaded)
+	}
+}
+
+func TestConcurrentMapCompareAndSwap(t *testing.T) {
+	m := NewConcurrentMap[string, int]()
+	m.Store("a", 1)
+
+	if m.CompareAndSwap("a", 2, 3) {
+		t.Fatal("CompareAndSwap with a stale old value should fail")
+	}
+	if !m.CompareAndSwap("a", 1, 3) {
+		t.Fatal("CompareAndSwap with the current value should succeed")
+	}
+	if v, _ := m.Load("a"); v != 3 {
+		t.Fatalf("Load(a) = %v after CompareAndSwap, want 3", v)
+	}
+	if m.CompareAndSwap("missing", 0, 1) {
+		t.Fatal("CompareAndSwap on a missing key should fail")
+	}
+}
+
+func TestConcurrentMapSnapshot(t *testing.T) {
+	m := NewConcurrentMap[string, int]()
+	m.Store("a", 1)
+	m.Store("b", 2)
+
+	snap := m.Snapshot()
+	if got := snap.Size(); got != 2 {
+		t.Fatalf("Snapshot().Size() = %d, want 2", got)
+	}
+
+	m.Store("c", 3)
+	if got := snap.Size(); got != 2 {
+		t.Fatalf("Snapshot mutated after the original map changed: Size() = %d, want 2", got)
+	}
+	if _, ok := snap.Lookup("c"); ok {
+		t.Fatal("Snapshot should not observe writes made after it was taken")
+	}
+}
+
+// TestConcurrentMapConcurrentAccess drives many goroutines through Store,
+// LoadOrStore, CompareAndSwap and Delete at once so -race can catch any
+// data race in the atomic-pointer/mutex-guarded expansion logic.
+func TestConcurrentMapConcurrentAccess(t *testing.T) {
+	m := NewConcurrentMap[int, int]()
+	const goroutines = 32
+	const perGoroutine = 200
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				key := g*perGoroutine + i
+				m.Store(key, i)
+				if v, ok := m.Load(key); !ok || v != i {
+					t.Errorf("Load(%d) = %v, %v, want %d, true", key, v, ok, i)
+				}
+				m.CompareAndSwap(key, i, i+1)
+				m.LoadOrStore(key, -1)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	snap := m.Snapshot()
+	if got := snap.Size(); got != goroutines*perGoroutine {
+		t.Fatalf("Snapshot().Size() = %d, want %d", got, goroutines*perGoroutine)
+	}
+
+	var wg2 sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg2.Add(1)
+		go func(g int) {
+			defer wg2.Done()
+			for i := 0; i < perGoroutine; i++ {
+				m.Delete(g*perGoroutine + i)
+			}
+		}(g)
+	}
+	wg2.Wait()
+
+	if got := m.Snapshot().Size(); got != 0 {
+		t.Fatalf("Snapshot().Size() = %d after deleting every key, want 0", got)
+	}
+}
+
+// slotCollidingKeys returns two keys whose hashes land in the same
+// level-0 slot (hashChunk(hash, 0) agrees) but whose hashes differ, the
+// precondition for upsert's structural-split branch.
+func slotCollidingKeys(t *testing.T) (a, b string) {
+	t.Helper()
+	a = "seed"
+	ha := hashAny(a)
+	idx := hashChunk(ha, 0)
+	for i := 0; i < 1_000_000; i++ {
+		b := fmt.Sprintf("k%d", i)
+		hb := hashAny(b)
+		if hb != ha && hashChunk(hb, 0) == idx {
+			return a, b
+		}
+	}
+	t.Fatal("couldn't find two keys colliding in the same level-0 slot")
+	return "", ""
+}
+
+// TestConcurrentMapStructuralSplitRace reproduces a race where a Store
+// landing concurrently with another key's structural split (turning a
+// single-leaf slot into a child node) must not be silently discarded by
+// the split committing with a stale copy of the slot's prior leaf.
+func TestConcurrentMapStructuralSplitRace(t *testing.T) {
+	a, b := slotCollidingKeys(t)
+
+	for trial := 0; trial < 500; trial++ {
+		m := NewConcurrentMap[string, int]()
+		m.Store(a, 0) // occupies the shared slot as a single-leaf entry
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.Store(a, 999) // updates the resident leaf
+		}()
+		go func() {
+			defer wg.Done()
+			m.Store(b, 1) // forces the slot to split into a child node
+		}()
+		wg.Wait()
+
+		if v, ok := m.Load(a); !ok || v != 999 {
+			t.Fatalf("trial %d: Load(a) = %v, %v, want 999, true (split must not discard a concurrent update)", trial, v, ok)
+		}
+		if v, ok := m.Load(b); !ok || v != 1 {
+			t.Fatalf("trial %d: Load(b) = %v, %v, want 1, true", trial, v, ok)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package ps
+
+import (
+	"math/bits"
+	"reflect"
+)
+
+// Diff compares two maps derived from one another (e.g. new is old plus a
+// handful of Sets) and returns what changed: added holds keys only in
+// new, removed holds keys only in old, and changed holds keys present in
+// both whose value differs. Because the HAMT shares structure between
+// related maps, Diff skips any subtree the two maps point at identically,
+// making it O(changes) rather than O(size) for maps that mostly agree.
+//
+// A key present in both maps is reported in changed only if its value
+// differs, using its Equatable implementation if it has one and
+// reflect.DeepEqual otherwise - the same rule Set and SetFunc use to
+// detect a no-op update.
+func Diff[K comparable, V any](old, new Map[K, V]) (added, removed, changed Map[K, V]) {
+	added, removed, changed = NewMap[K, V](), NewMap[K, V](), NewMap[K, V]()
+
+	ho, aok := old.(*hamtMap[K, V])
+	hn, bok := new.(*hamtMap[K, V])
+	if !aok || !bok {
+		// Some other Map implementation: no structural sharing to
+		// exploit, so fall back to a full scan of both sides.
+		old.ForEach(func(k K, v V) {
+			if nv, found := new.Lookup(k); found {
+				if !compareValues(v, nv, nil) {
+					changed = changed.Set(k, nv)
+				}
+			} else {
+				removed = removed.Set(k, v)
+			}
+		})
+		new.ForEach(func(k K, v V) {
+			if _, found := old.Lookup(k); !found {
+				added = added.Set(k, v)
+			}
+		})
+		return
+	}
+
+	diffNode[K, V](ho.root, hn.root, &added, &removed, &changed)
+	return
+}
+
+func diffNode[K comparable, V any](o, n mapNode[K, V], added, removed, changed *Map[K, V]) {
+	if o == nil && n == nil {
+		return
+	}
+	if o == nil {
+		forEachNode[K, V](n, func(k K, v V) { *added = (*added).Set(k, v) })
+		return
+	}
+	if n == nil {
+		forEachNode[K, V](o, func(k K, v V) { *removed = (*removed).Set(k, v) })
+		return
+	}
+	if sameNode[K, V](o, n) {
+		return // identical subtree: nothing beneath it can have changed
+	}
+
+	ob, obOK := o.(*branch[K, V])
+	nb, nbOK := n.(*branch[K, V])
+	if obOK && nbOK {
+		diffBranches(ob, nb, added, removed, changed)
+		return
+	}
+
+	// Leaves and collision nodes are small (collisions are rare and
+	// capped by how many keys can truly share a hash); comparing their
+	// key sets directly is simpler than special-casing every
+	// leaf/collision/branch combination and costs little since the
+	// common, high-leverage case - large shared branches - was already
+	// handled above.
+	diffByKeySet[K, V](o, n, added, removed, changed)
+}
+
+func diffBranches[K comparable, V any](ob, nb *branch[K, V], added, removed, changed *Map[K, V]) {
+	for bm := ob.bitmap | nb.bitmap; bm != 0; bm &= bm - 1 {
+		bit := bm & -bm
+		oHas := ob.bitmap&bit != 0
+		nHas := nb.bitmap&bit != 0
+		switch {
+		case oHas && nHas:
+			oPos := bits.OnesCount32(ob.bitmap & (bit - 1))
+			nPos := bits.OnesCount32(nb.bitmap & (bit - 1))
+			diffNode[K, V](ob.entries[oPos], nb.entries[nPos], added, removed, changed)
+		case oHas:
+			oPos := bits.OnesCount32(ob.bitmap & (bit - 1))
+			forEachNode[K, V](ob.entries[oPos], func(k K, v V) { *removed = (*removed).Set(k, v) })
+		case nHas:
+			nPos := bits.OnesCount32(nb.bitmap & (bit - 1))
+			forEachNode[K, V](nb.entries[nPos], func(k K, v V) { *added = (*added).Set(k, v) })
+		}
+	}
+}
+
+func diffByKeySet[K comparable, V any](o, n mapNode[K, V], added, removed, changed *Map[K, V]) {
+	oKeys := make(map[K]V)
+	forEachNode[K, V](o, func(k K, v V) { oKeys[k] = v })
+	nKeys := make(map[K]V)
+	forEachNode[K, V](n, func(k K, v V) { nKeys[k] = v })
+
+	for k, v := range oKeys {
+		if nv, ok := nKeys[k]; ok {
+			if !compareValues(v, nv, nil) {
+				*changed = (*changed).Set(k, nv)
+			}
+		} else {
+			*removed = (*removed).Set(k, v)
+		}
+	}
+	for k, v := range nKeys {
+		if _, ok := oKeys[k]; !ok {
+			*added = (*added).Set(k, v)
+		}
+	}
+}
+
+// Merge combines a and b into a single map. Keys present in only one of
+// the two carry over unchanged; keys present in both are resolved by
+// calling resolve. Like Diff, Merge skips any subtree the two maps share
+// by identity instead of walking and rebuilding it.
+func Merge[K comparable, V any](a, b Map[K, V], resolve func(k K, av, bv V) V) Map[K, V] {
+	ha, aok := a.(*hamtMap[K, V])
+	hb, bok := b.(*hamtMap[K, V])
+	if !aok || !bok {
+		result := a
+		b.ForEach(func(k K, v V) {
+			if av, found := result.Lookup(k); found {
+				result = result.Set(k, resolve(k, av, v))
+			} else {
+				result = result.Set(k, v)
+			}
+		})
+		return result
+	}
+
+	return &hamtMap[K, V]{root: mergeNode[K, V](ha.root, hb.root, 0, resolve)}
+}
+
+func mergeNode[K comparable, V any](a, b mapNode[K, V], level int, resolve func(k K, av, bv V) V) mapNode[K, V] {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if sameNode[K, V](a, b) {
+		return a
+	}
+
+	ab, aok := a.(*branch[K, V])
+	bb, bok := b.(*branch[K, V])
+	if aok && bok {
+		return mergeBranches(ab, bb, level, resolve)
+	}
+
+	merged := make(map[K]V)
+	forEachNode[K, V](a, func(k K, v V) { merged[k] = v })
+	forEachNode[K, V](b, func(k K, v V) {
+		if av, ok := merged[k]; ok {
+			merged[k] = resolve(k, av, v)
+		} else {
+			merged[k] = v
+		}
+	})
+	var out mapNode[K, V]
+	for k, v := range merged {
+		out, _ = setNode[K, V](out, hashAny(k), level, k, v, nil)
+	}
+	return out
+}
+
+func mergeBranches[K comparable, V any](ab, bb *branch[K, V], level int, resolve func(k K, av, bv V) V) *branch[K, V] {
+	bitmap := ab.bitmap | bb.bitmap
+	entries := make([]mapNode[K, V], 0, bits.OnesCount32(bitmap))
+	count := 0
+	for bm := bitmap; bm != 0; bm &= bm - 1 {
+		bit := bm & -bm
+		aHas := ab.bitmap&bit != 0
+		bHas := bb.bitmap&bit != 0
+
+		var child mapNode[K, V]
+		switch {
+		case aHas && bHas:
+			aPos := bits.OnesCount32(ab.bitmap & (bit - 1))
+			bPos := bits.OnesCount32(bb.bitmap & (bit - 1))
+			child = mergeNode[K, V](ab.entries[aPos], bb.entries[bPos], level+1, resolve)
+		case aHas:
+			child = ab.entries[bits.OnesCount32(ab.bitmap&(bit-1))]
+		default:
+			child = bb.entries[bits.OnesCount32(bb.bitmap&(bit-1))]
+		}
+		entries = append(entries, child)
+		count += child.size()
+	}
+	return &branch[K, V]{bitmap: bitmap, entries: entries, count: count}
+}
+
+// Equal reports whether a and b contain the same keys mapped to equal
+// values, using eq to compare values (or each value's Equatable
+// implementation if eq is nil). Like Diff and Merge, it short-circuits on
+// identical subtrees rather than visiting every key.
+func Equal[K comparable, V any](a, b Map[K, V], eq func(x, y V) bool) bool {
+	if a.Size() != b.Size() {
+		return false
+	}
+	ha, aok := a.(*hamtMap[K, V])
+	hb, bok := b.(*hamtMap[K, V])
+	if aok && bok {
+		return equalNode[K, V](ha.root, hb.root, eq)
+	}
+
+	equal := true
+	a.ForEach(func(k K, v V) {
+		if !equal {
+			return
+		}
+		bv, found := b.Lookup(k)
+		if !found || !compareValues(v, bv, eq) {
+			equal = false
+		}
+	})
+	return equal
+}
+
+func equalNode[K comparable, V any](a, b mapNode[K, V], eq func(x, y V) bool) bool {
+	if a == nil && b == nil {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	if sameNode[K, V](a, b) {
+		return true
+	}
+
+	switch x := a.(type) {
+	case entryLeaf[K, V]:
+		y, ok := b.(entryLeaf[K, V])
+		return ok && x.key == y.key && compareValues(x.value, y.value, eq)
+
+	case *collisionNode[K, V]:
+		y, ok := b.(*collisionNode[K, V])
+		if !ok || len(x.pairs) != len(y.pairs) {
+			return false
+		}
+		for _, p := range x.pairs {
+			matched := false
+			for _, q := range y.pairs {
+				if q.key == p.key {
+					matched = compareValues(p.value, q.value, eq)
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+		return true
+
+	case *branch[K, V]:
+		y, ok := b.(*branch[K, V])
+		if !ok || x.bitmap != y.bitmap {
+			return false
+		}
+		for i := range x.entries {
+			if !equalNode[K, V](x.entries[i], y.entries[i], eq) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compareValues reports whether a and b should be treated as equal for
+// Diff/Merge/Equal purposes when no identical-subtree shortcut applies:
+// via eq if supplied, then a's Equatable implementation if it has one,
+// then reflect.DeepEqual.
+func compareValues[V any](a, b V, eq func(x, y V) bool) bool {
+	if eq != nil {
+		return eq(a, b)
+	}
+	if e, ok := any(a).(Equatable[V]); ok {
+		return e.Equal(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sameNode reports whether a and b are the same node by pointer identity.
+// It only ever returns true for branch and collision nodes: entryLeaf is
+// a plain value (not a pointer) carrying a V that may not be comparable,
+// so it can't safely be compared with ==.
+func sameNode[K comparable, V any](a, b mapNode[K, V]) bool {
+	switch x := a.(type) {
+	case *branch[K, V]:
+		y, ok := b.(*branch[K, V])
+		return ok && x == y
+	case *collisionNode[K, V]:
+		y, ok := b.(*collisionNode[K, V])
+		return ok && x == y
+	default:
+		return false
+	}
+}
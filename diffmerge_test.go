@@ -0,0 +1,158 @@
+package ps
+
+import "testing"
+
+func TestEqualIdentical(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1).Set("y", 2)
+	if !Equal[string, int](a, a, nil) {
+		t.Fatal("a map should equal itself")
+	}
+}
+
+// TestEqualSameValuesDifferentStructure builds two maps with the same
+// key/value pairs via different Set orders, so they end up as distinct
+// trie structures with no shared subtrees to exploit via pointer
+// identity. Equal still has to report them as equal.
+func TestEqualSameValuesDifferentStructure(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1).Set("y", 2)
+	b := NewMap[string, int]().Set("y", 2).Set("x", 1)
+	if !Equal[string, int](a, b, nil) {
+		t.Fatal("maps holding the same pairs should be Equal regardless of insertion order")
+	}
+
+	c := NewMap[string, int]().Set("x", 1)
+	d := NewMap[string, int]().Set("x", 1)
+	if !Equal[string, int](c, d, nil) {
+		t.Fatal("two independently-built single-entry maps with equal values should be Equal")
+	}
+}
+
+func TestEqualDifferingValues(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1)
+	b := NewMap[string, int]().Set("x", 2)
+	if Equal[string, int](a, b, nil) {
+		t.Fatal("maps with a differing value should not be Equal")
+	}
+}
+
+func TestEqualDifferingSize(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1)
+	b := NewMap[string, int]().Set("x", 1).Set("y", 2)
+	if Equal[string, int](a, b, nil) {
+		t.Fatal("maps of different size should not be Equal")
+	}
+}
+
+func TestEqualCustomFunc(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1)
+	b := NewMap[string, int]().Set("x", -1)
+	eq := func(x, y int) bool {
+		if x < 0 {
+			x = -x
+		}
+		if y < 0 {
+			y = -y
+		}
+		return x == y
+	}
+	if !Equal[string, int](a, b, eq) {
+		t.Fatal("Equal should use the supplied eq function")
+	}
+}
+
+func TestDiffAddedRemovedChanged(t *testing.T) {
+	base := NewMap[string, int]().Set("k1", 1).Set("k2", 2)
+	next := base.Set("k2", 22).Set("k3", 3)
+
+	added, removed, changed := Diff[string, int](base, next)
+
+	if got := added.Size(); got != 1 {
+		t.Fatalf("added.Size() = %d, want 1", got)
+	}
+	if v, ok := added.Lookup("k3"); !ok || v != 3 {
+		t.Fatalf("added.Lookup(k3) = %v, %v, want 3, true", v, ok)
+	}
+	if got := removed.Size(); got != 0 {
+		t.Fatalf("removed.Size() = %d, want 0", got)
+	}
+	if got := changed.Size(); got != 1 {
+		t.Fatalf("changed.Size() = %d, want 1 (only k2, not the unchanged k1)", got)
+	}
+	if v, ok := changed.Lookup("k2"); !ok || v != 22 {
+		t.Fatalf("changed.Lookup(k2) = %v, %v, want 22, true", v, ok)
+	}
+}
+
+// TestDiffEqualValuesNotReportedChanged is the structural counterpart of
+// TestEqualSameValuesDifferentStructure: two maps that agree on every key
+// but were built independently, so they share no subtrees by identity,
+// must not show up in changed.
+func TestDiffEqualValuesNotReportedChanged(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1)
+	b := NewMap[string, int]().Set("x", 1)
+
+	added, removed, changed := Diff[string, int](a, b)
+	if got := added.Size(); got != 0 {
+		t.Fatalf("added.Size() = %d, want 0", got)
+	}
+	if got := removed.Size(); got != 0 {
+		t.Fatalf("removed.Size() = %d, want 0", got)
+	}
+	if got := changed.Size(); got != 0 {
+		t.Fatalf("changed.Size() = %d, want 0 (values agree)", got)
+	}
+}
+
+func TestDiffRemoved(t *testing.T) {
+	base := NewMap[string, int]().Set("k1", 1).Set("k2", 2)
+	next := base.Delete("k1")
+
+	added, removed, changed := Diff[string, int](base, next)
+	if got := added.Size(); got != 0 {
+		t.Fatalf("added.Size() = %d, want 0", got)
+	}
+	if got := changed.Size(); got != 0 {
+		t.Fatalf("changed.Size() = %d, want 0", got)
+	}
+	if got := removed.Size(); got != 1 {
+		t.Fatalf("removed.Size() = %d, want 1", got)
+	}
+	if v, ok := removed.Lookup("k1"); !ok || v != 1 {
+		t.Fatalf("removed.Lookup(k1) = %v, %v, want 1, true", v, ok)
+	}
+}
+
+func TestMergeResolvesConflicts(t *testing.T) {
+	a := NewMap[string, int]().Set("x", 1).Set("y", 2)
+	b := NewMap[string, int]().Set("y", 20).Set("z", 3)
+
+	merged := Merge[string, int](a, b, func(k string, av, bv int) int { return av + bv })
+
+	if got := merged.Size(); got != 3 {
+		t.Fatalf("merged.Size() = %d, want 3", got)
+	}
+	if v, _ := merged.Lookup("x"); v != 1 {
+		t.Fatalf("merged.Lookup(x) = %v, want 1", v)
+	}
+	if v, _ := merged.Lookup("y"); v != 22 {
+		t.Fatalf("merged.Lookup(y) = %v, want 22 (resolved 2+20)", v)
+	}
+	if v, _ := merged.Lookup("z"); v != 3 {
+		t.Fatalf("merged.Lookup(z) = %v, want 3", v)
+	}
+}
+
+func TestMergeIdenticalSubtreeShortcut(t *testing.T) {
+	shared := NewMap[string, int]().Set("x", 1).Set("y", 2)
+	called := false
+	merged := Merge[string, int](shared, shared, func(k string, av, bv int) int {
+		called = true
+		return av
+	})
+	if called {
+		t.Fatal("Merge should skip resolve entirely for identical subtrees")
+	}
+	if got := merged.Size(); got != 2 {
+		t.Fatalf("merged.Size() = %d, want 2", got)
+	}
+}
@@ -12,11 +12,32 @@ package ps
 import (
 	"bytes"
 	"fmt"
+	"math/bits"
+	"reflect"
 	"unsafe"
 )
 
-// A Map associates unique keys (type string) with values (type Any).
-type Map interface {
+// Equatable may be implemented by values stored in a Map. When a value
+// being Set implements Equatable, Set (and SetFunc) use it to detect a
+// no-op update and return the receiver unchanged instead of cloning the
+// path down to the new node.
+type Equatable[V any] interface {
+	Equal(other V) bool
+}
+
+// A Map associates unique keys (type K) with values (type V).
+//
+// This is a breaking change from the pre-generics Map, which was fixed to
+// string keys and interface{} values: Go doesn't allow a generic type and
+// a non-generic type to share one name, so reusing the name Map here for
+// the generic interface means every caller of the old Map - whether
+// through ps.Map, ps.NewMap(), or its own implementation of the old
+// interface - fails to compile as-is, with no transitional period. The
+// old shape survives only as StringMap (= Map[string, any]) below, under
+// a new name; nothing automatically binds old call sites to it. Callers
+// migrating an existing dependency on this package need to update their
+// own ps.Map references to ps.StringMap by hand before upgrading.
+type Map[K comparable, V any] interface {
 	// IsNil returns true if the Map is empty
 	IsNil() bool
 
@@ -24,81 +45,136 @@ type Map interface {
 	// If the key didn't exist before, it's created; otherwise, the
 	// associated value is changed.
 	// This operation is O(log N) in the number of keys.
-	Set(key string, value interface{}) Map
+	Set(key K, value V) Map[K, V]
+
+	// SetFunc is like Set, but eq is used to compare the new value
+	// against any existing value for key. If eq reports the values
+	// equal, the receiver is returned unchanged rather than cloned.
+	// A nil eq falls back to the existing value's Equatable
+	// implementation if it has one, then to reflect.DeepEqual.
+	SetFunc(key K, value V, eq func(old, new V) bool) Map[K, V]
 
 	// UnsafeMutableSet returns the same map in which key and value are associated in-place.
 	// If the key didn't exist before, it's created; otherwise, the
 	// associated value is changed.
 	// This operation is O(log N) in the number of keys.
 	// Only use UnsafeMutableSet if you are the only reference-holder of the Map.
-	UnsafeMutableSet(key string, value interface{}) Map
+	UnsafeMutableSet(key K, value V) Map[K, V]
 
 	// Delete returns a new map with the association for key, if any, removed.
 	// This operation is O(log N) in the number of keys.
-	Delete(key string) Map
+	Delete(key K) Map[K, V]
 
 	// Lookup returns the value associated with a key, if any.  If the key
 	// exists, the second return value is true; otherwise, false.
 	// This operation is O(log N) in the number of keys.
-	Lookup(key string) (interface{}, bool)
+	Lookup(key K) (V, bool)
 
-	// First returns the "first" value in the map, if any, or nil.
-	First() interface{}
+	// First returns the "first" value in the map, if any, or the zero
+	// value of V.
+	First() V
 
 	// Size returns the number of key value pairs in the map.
 	// This takes O(1) time.
 	Size() int
 
 	// ForEach executes a callback on each key value pair in the map.
-	ForEach(f func(key string, val interface{}))
+	ForEach(f func(key K, val V))
 
 	// Keys returns a slice with all keys in this map.
 	// This operation is O(N) in the number of keys.
-	Keys() []string
+	Keys() []K
+
+	// Txn starts a transaction that can batch many mutations into a
+	// single pending trie before publishing a new, immutable Map via
+	// Commit. Prefer it over repeated Set calls when building a large
+	// map, since Set clones a fresh spine on every call.
+	Txn() *Txn[K, V]
 
 	String() string
 }
 
-// Immutable (i.e. persistent) associative array
-const childCount = 8
-const shiftSize = 3
+// StringMap is the pre-generics Map[string, any] shape, kept under this
+// new name as a thin instantiation of the generic Map so the old
+// string/interface{} API (e.g. mndrix/ps consumers) is still available
+// to update existing call sites to, even though upgrading past this
+// change isn't itself incremental - see the warning on Map above.
+type StringMap = Map[string, any]
+
+// NewStringMap allocates a new, persistent map from strings to values of
+// any type. It's equivalent to the pre-generics NewMap().
+func NewStringMap() StringMap {
+	return NewMap[string, any]()
+}
 
-type tree struct {
-	count    int
-	hash     uint64 // hash of the key (used for tree balancing)
-	key      string
-	value    interface{}
-	children [childCount]*tree
+// Immutable (i.e. persistent) associative array, implemented as a Hash
+// Array Mapped Trie (HAMT). Each level of the trie branches on a 5-bit
+// chunk of the key's 64-bit hash, so a branch node holds at most 32
+// children; unlike a plain array of 32 pointers, a branch only allocates
+// storage for the slots it actually uses, tracked by a 32-bit bitmap and a
+// compact entries slice sized to popcount(bitmap).
+const (
+	bitsPerLevel = 5
+	levelMask    = 1<<bitsPerLevel - 1
+	maxLevel     = (64 + bitsPerLevel - 1) / bitsPerLevel // beyond this, hash bits are exhausted
+)
+
+// mapNode is the sum type of the three kinds of trie node: a leaf holding a
+// single key/value pair, a branch holding a compact array of child nodes,
+// and a collision node holding every key/value pair whose hash is
+// identical (reached only once bitsPerLevel*level has consumed all 64
+// hash bits).
+type mapNode[K comparable, V any] interface {
+	size() int
 }
 
-var nilMap = &tree{}
+// entryLeaf is a leaf node: a single key/value pair plus the hash that
+// placed it, kept around so it can be pushed further down the trie if a
+// colliding key is inserted later.
+type entryLeaf[K comparable, V any] struct {
+	hash  uint64
+	key   K
+	value V
+}
 
-// Recursively set nilMap's subtrees to point at itself.
-// This eliminates all nil pointers in the map structure.
-// All map nodes are created by cloning this structure so
-// they avoid the problem too.
-func init() {
-	for i := range nilMap.children {
-		nilMap.children[i] = nilMap
-	}
+func (e entryLeaf[K, V]) size() int { return 1 }
+
+// branch is an interior trie node. bitmap has a 1 bit for every occupied
+// child slot (indexed by a 5-bit hash chunk); entries holds exactly
+// popcount(bitmap) children, ordered to match the bitmap's set bits.
+type branch[K comparable, V any] struct {
+	bitmap  uint32
+	entries []mapNode[K, V]
+	count   int
 }
 
-// NewMap allocates a new, persistent map from strings to values of
-// any type.
-// This is currently implemented as a path-copying binary tree.
-func NewMap() Map {
-	return nilMap
+func (b *branch[K, V]) size() int { return b.count }
+
+// collisionNode holds every key/value pair that shares the same 64-bit
+// hash, once the trie has branched on all available hash bits (maxLevel
+// deep) and the keys still differ. This replaces the panic the previous
+// 8-ary tree raised on a real hash collision.
+type collisionNode[K comparable, V any] struct {
+	hash  uint64
+	pairs []entryLeaf[K, V]
 }
 
-func (self *tree) IsNil() bool {
-	return self == nilMap
+func (c *collisionNode[K, V]) size() int { return len(c.pairs) }
+
+// hamtMap is the persistent Map implementation backed by a HAMT.
+type hamtMap[K comparable, V any] struct {
+	root mapNode[K, V] // nil root means an empty map
 }
 
-// clone returns an exact duplicate of a tree node
-func (self *tree) clone() *tree {
-	var m tree
-	m = *self
-	return &m
+// NewMap allocates a new, persistent map from keys of type K to values of
+// type V.
+// This is currently implemented as a hash array mapped trie (HAMT).
+func NewMap[K comparable, V any]() Map[K, V] {
+	return &hamtMap[K, V]{}
+}
+
+func (h *hamtMap[K, V]) IsNil() bool {
+	return h.root == nil
 }
 
 // constants for FNV-1a hash algorithm
@@ -144,255 +220,388 @@ func hashKey(key string) uint64 {
 	return hash
 }
 
-// Set returns a new map similar to this one but with key and value
-// associated.  If the key didn't exist, it's created; otherwise, the
-// associated value is changed.
-func (self *tree) Set(key string, value interface{}) Map {
-	hash := hashKey(key)
-	return setLowLevel(self, hash, hash, key, value)
-}
-
-func setLowLevel(self *tree, partialHash, hash uint64, key string, value interface{}) *tree {
-	if self.IsNil() { // an empty tree is easy
-		m := self.clone()
-		m.count = 1
-		m.hash = hash
-		m.key = key
-		m.value = value
-		return m
+// hashAny returns a hash code for an arbitrary comparable key. Common
+// built-in kinds are hashed directly; anything else falls back to hashing
+// its default string representation. Hash equality only ever decides how
+// far two keys share a path down the trie - key equality is still checked
+// with == - so this fallback can't affect correctness, only how evenly
+// keys without a dedicated case are spread.
+func hashAny[K comparable](key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return hashKey(k)
+	case int:
+		return hashKey(fmt.Sprintf("%d", k))
+	case int32:
+		return hashKey(fmt.Sprintf("%d", k))
+	case int64:
+		return hashKey(fmt.Sprintf("%d", k))
+	case uint:
+		return hashKey(fmt.Sprintf("%d", k))
+	case uint32:
+		return hashKey(fmt.Sprintf("%d", k))
+	case uint64:
+		return hashKey(fmt.Sprintf("%d", k))
+	default:
+		return hashKey(fmt.Sprintf("%v", key))
 	}
+}
 
-	if hash != self.hash {
-		m := self.clone()
-		i := partialHash % childCount
-		m.children[i] = setLowLevel(self.children[i], partialHash>>shiftSize, hash, key, value)
-		// update count if we added a new object
-		if m.children[i].count > self.children[i].count {
-			m.count++
-		}
-		return m
-	}
+// hashChunk extracts the 5-bit chunk of hash used for branching at level.
+func hashChunk(hash uint64, level int) uint32 {
+	return uint32(hash>>(level*bitsPerLevel)) & levelMask
+}
 
-	// did we find a hash collision?
-	if key != self.key {
-		oops := fmt.Sprintf("Hash collision between: '%s' and '%s'.  Please report to https://github.com/mndrix/ps/issues/new", self.key, key)
-		panic(oops)
+// valuesEqual reports whether new is a no-op replacement for old, using eq
+// if supplied, then old's Equatable implementation if it has one, then
+// reflect.DeepEqual.
+func valuesEqual[V any](old, new V, eq func(old, new V) bool) bool {
+	if eq != nil {
+		return eq(old, new)
 	}
-
-	// replacing a key's previous value
-	m := self.clone()
-	m.value = value
-	return m
+	if e, ok := any(old).(Equatable[V]); ok {
+		return e.Equal(new)
+	}
+	return reflect.DeepEqual(old, new)
 }
 
-// UnsafeMutableSet is the in-place mutable version of Set. Only use if
-// you are the only reference-holder of the Map.
-func (self *tree) UnsafeMutableSet(key string, value interface{}) Map {
-	hash := hashKey(key)
-	return mutableSetLowLevel(self, hash, hash, key, value)
-}
-
-func mutableSetLowLevel(self *tree, partialHash, hash uint64, key string, value interface{}) *tree {
-	if self.IsNil() { // an empty tree is easy
-		m := self.clone()
-		m.count = 1
-		m.hash = hash
-		m.key = key
-		m.value = value
-		return m
+// mergeLeaves builds the smallest subtrie containing both a (already
+// present) and b (being inserted), splitting on successive hash chunks
+// until they land in different slots. If they never do - a real hash
+// collision once maxLevel is reached - it falls back to a collisionNode.
+func mergeLeaves[K comparable, V any](level int, a, b entryLeaf[K, V]) mapNode[K, V] {
+	if level >= maxLevel {
+		return &collisionNode[K, V]{hash: a.hash, pairs: []entryLeaf[K, V]{a, b}}
 	}
 
-	if hash != self.hash {
-		i := partialHash % childCount
-		oldChildCount := self.children[i].count
-		self.children[i] = mutableSetLowLevel(self.children[i], partialHash>>shiftSize, hash, key, value)
-		// update count if we added a new object
-		if oldChildCount < self.children[i].count {
-			self.count++
-		}
-		return self
+	idxA := hashChunk(a.hash, level)
+	idxB := hashChunk(b.hash, level)
+	if idxA == idxB {
+		child := mergeLeaves(level+1, a, b)
+		return &branch[K, V]{bitmap: 1 << idxA, entries: []mapNode[K, V]{child}, count: 2}
 	}
 
-	// did we find a hash collision?
-	if key != self.key {
-		oops := fmt.Sprintf("Hash collision between: '%s' and '%s'.  Please report to https://github.com/mndrix/ps/issues/new", self.key, key)
-		panic(oops)
+	entries := []mapNode[K, V]{a, b}
+	if idxA > idxB {
+		entries[0], entries[1] = b, a
 	}
-
-	// replacing a key's previous value
-	self.value = value
-	return self
+	return &branch[K, V]{bitmap: 1<<idxA | 1<<idxB, entries: entries, count: 2}
 }
 
-// modifies a map by recalculating its key count based on the counts
-// of its subtrees
-func recalculateCount(m *tree) {
-	count := 0
-	for _, t := range m.children {
-		count += t.Size()
-	}
-	m.count = count + 1 // add one to count ourself
+// Set returns a new map similar to this one but with key and value
+// associated.  If the key didn't exist, it's created; otherwise, the
+// associated value is changed.
+func (h *hamtMap[K, V]) Set(key K, value V) Map[K, V] {
+	return h.SetFunc(key, value, nil)
 }
 
-func (m *tree) Delete(key string) Map {
-	hash := hashKey(key)
-	newMap, _ := deleteLowLevel(m, hash, hash)
-	return newMap
+// SetFunc is like Set, but skips cloning the path to key when eq reports
+// the new value equal to the one already stored there.
+func (h *hamtMap[K, V]) SetFunc(key K, value V, eq func(old, new V) bool) Map[K, V] {
+	hash := hashAny(key)
+	newRoot, changed := setNode[K, V](h.root, hash, 0, key, value, eq)
+	if !changed {
+		return h
+	}
+	return &hamtMap[K, V]{root: newRoot}
 }
 
-func deleteLowLevel(self *tree, partialHash, hash uint64) (*tree, bool) {
-	// empty trees are easy
-	if self.IsNil() {
-		return self, false
-	}
+// setNode returns the (possibly new) node with key/value set, and whether
+// anything actually changed (false means n is a no-op replacement and was
+// returned unmodified).
+func setNode[K comparable, V any](n mapNode[K, V], hash uint64, level int, key K, value V, eq func(old, new V) bool) (mapNode[K, V], bool) {
+	switch t := n.(type) {
+	case nil:
+		return entryLeaf[K, V]{hash: hash, key: key, value: value}, true
+
+	case entryLeaf[K, V]:
+		if t.key == key {
+			if valuesEqual(t.value, value, eq) {
+				return t, false
+			}
+			return entryLeaf[K, V]{hash: hash, key: key, value: value}, true
+		}
+		return mergeLeaves(level, t, entryLeaf[K, V]{hash: hash, key: key, value: value}), true
+
+	case *collisionNode[K, V]:
+		for i, p := range t.pairs {
+			if p.key == key {
+				if valuesEqual(p.value, value, eq) {
+					return t, false
+				}
+				pairs := append([]entryLeaf[K, V]{}, t.pairs...)
+				pairs[i] = entryLeaf[K, V]{hash: hash, key: key, value: value}
+				return &collisionNode[K, V]{hash: t.hash, pairs: pairs}, true
+			}
+		}
+		pairs := append(append([]entryLeaf[K, V]{}, t.pairs...), entryLeaf[K, V]{hash: hash, key: key, value: value})
+		return &collisionNode[K, V]{hash: t.hash, pairs: pairs}, true
+
+	case *branch[K, V]:
+		idx := hashChunk(hash, level)
+		bit := uint32(1) << idx
+		pos := bits.OnesCount32(t.bitmap & (bit - 1))
+
+		if t.bitmap&bit == 0 {
+			entries := make([]mapNode[K, V], len(t.entries)+1)
+			copy(entries, t.entries[:pos])
+			entries[pos] = entryLeaf[K, V]{hash: hash, key: key, value: value}
+			copy(entries[pos+1:], t.entries[pos:])
+			return &branch[K, V]{bitmap: t.bitmap | bit, entries: entries, count: t.count + 1}, true
+		}
 
-	if hash != self.hash {
-		i := partialHash % childCount
-		child, found := deleteLowLevel(self.children[i], partialHash>>shiftSize, hash)
-		if !found {
-			return self, false
+		child, changed := setNode[K, V](t.entries[pos], hash, level+1, key, value, eq)
+		if !changed {
+			return t, false
 		}
-		newMap := self.clone()
-		newMap.children[i] = child
-		recalculateCount(newMap)
-		return newMap, true // ? this wasn't in the original code
+		entries := append([]mapNode[K, V]{}, t.entries...)
+		entries[pos] = child
+		count := t.count - t.entries[pos].size() + child.size()
+		return &branch[K, V]{bitmap: t.bitmap, entries: entries, count: count}, true
 	}
+	panic("ps: unreachable mapNode kind")
+}
 
-	// we must delete our own node
-	if self.isLeaf() { // we have no children
-		return nilMap, true
-	}
-	/*
-	   if self.subtreeCount() == 1 { // only one subtree
-	       for _, t := range self.children {
-	           if t != nilMap {
-	               return t, true
-	           }
-	       }
-	       panic("Tree with 1 subtree actually had no subtrees")
-	   }
-	*/
-
-	// find a node to replace us
-	i := -1
-	size := -1
-	for j, t := range self.children {
-		if t.Size() > size {
-			i = j
-			size = t.Size()
+// UnsafeMutableSet is the in-place mutable version of Set. Only use if
+// you are the only reference-holder of the Map.
+func (h *hamtMap[K, V]) UnsafeMutableSet(key K, value V) Map[K, V] {
+	hash := hashAny(key)
+	h.root, _ = mutableSetNode[K, V](h.root, hash, 0, key, value)
+	return h
+}
+
+func mutableSetNode[K comparable, V any](n mapNode[K, V], hash uint64, level int, key K, value V) (mapNode[K, V], bool) {
+	switch t := n.(type) {
+	case nil:
+		return entryLeaf[K, V]{hash: hash, key: key, value: value}, true
+
+	case entryLeaf[K, V]:
+		if t.key == key {
+			return entryLeaf[K, V]{hash: hash, key: key, value: value}, false
+		}
+		return mergeLeaves(level, t, entryLeaf[K, V]{hash: hash, key: key, value: value}), true
+
+	case *collisionNode[K, V]:
+		for i, p := range t.pairs {
+			if p.key == key {
+				t.pairs[i] = entryLeaf[K, V]{hash: hash, key: key, value: value}
+				return t, false
+			}
+		}
+		t.pairs = append(t.pairs, entryLeaf[K, V]{hash: hash, key: key, value: value})
+		return t, true
+
+	case *branch[K, V]:
+		idx := hashChunk(hash, level)
+		bit := uint32(1) << idx
+		pos := bits.OnesCount32(t.bitmap & (bit - 1))
+
+		if t.bitmap&bit == 0 {
+			entries := make([]mapNode[K, V], len(t.entries)+1)
+			copy(entries, t.entries[:pos])
+			entries[pos] = entryLeaf[K, V]{hash: hash, key: key, value: value}
+			copy(entries[pos+1:], t.entries[pos:])
+			t.bitmap |= bit
+			t.entries = entries
+			t.count++
+			return t, true
 		}
-	}
 
-	// make chosen leaf smaller
-	replacement, child := self.children[i].deleteLeftmost()
-	newMap := replacement.clone()
-	for j := range self.children {
-		if j == i {
-			newMap.children[j] = child
-		} else {
-			newMap.children[j] = self.children[j]
+		child, added := mutableSetNode[K, V](t.entries[pos], hash, level+1, key, value)
+		t.entries[pos] = child
+		if added {
+			t.count++
 		}
+		return t, added
 	}
-	recalculateCount(newMap)
-	return newMap, true
+	panic("ps: unreachable mapNode kind")
 }
 
-// delete the leftmost node in a tree returning the node that
-// was deleted and the tree left over after its deletion
-func (m *tree) deleteLeftmost() (*tree, *tree) {
-	if m.isLeaf() {
-		return m, nilMap
+func (h *hamtMap[K, V]) Delete(key K) Map[K, V] {
+	hash := hashAny(key)
+	newRoot, deleted := deleteNode[K, V](h.root, hash, 0, key)
+	if !deleted {
+		return h
 	}
+	return &hamtMap[K, V]{root: newRoot}
+}
 
-	for i, t := range m.children {
-		if t != nilMap {
-			deleted, child := t.deleteLeftmost()
-			newMap := m.clone()
-			newMap.children[i] = child
-			recalculateCount(newMap)
-			return deleted, newMap
+// deleteNode returns the node with key removed (nil if that empties the
+// node entirely), and whether key was actually present.
+func deleteNode[K comparable, V any](n mapNode[K, V], hash uint64, level int, key K) (mapNode[K, V], bool) {
+	switch t := n.(type) {
+	case nil:
+		return nil, false
+
+	case entryLeaf[K, V]:
+		if t.key != key {
+			return t, false
 		}
-	}
-	panic("Tree isn't a leaf but also had no children. How does that happen?")
-}
+		return nil, true
+
+	case *collisionNode[K, V]:
+		for i, p := range t.pairs {
+			if p.key != key {
+				continue
+			}
+			pairs := append(append([]entryLeaf[K, V]{}, t.pairs[:i]...), t.pairs[i+1:]...)
+			if len(pairs) == 1 {
+				return pairs[0], true
+			}
+			return &collisionNode[K, V]{hash: t.hash, pairs: pairs}, true
+		}
+		return t, false
 
-// isLeaf returns true if this is a leaf node
-func (m *tree) isLeaf() bool {
-	return m.Size() == 1
-}
+	case *branch[K, V]:
+		idx := hashChunk(hash, level)
+		bit := uint32(1) << idx
+		if t.bitmap&bit == 0 {
+			return t, false
+		}
+		pos := bits.OnesCount32(t.bitmap & (bit - 1))
 
-// returns the number of child subtrees we have
-func (m *tree) subtreeCount() int {
-	count := 0
-	for _, t := range m.children {
-		if t != nilMap {
-			count++
+		child, deleted := deleteNode[K, V](t.entries[pos], hash, level+1, key)
+		if !deleted {
+			return t, false
 		}
+
+		if child == nil {
+			if t.count == 1 {
+				return nil, true
+			}
+			entries := make([]mapNode[K, V], len(t.entries)-1)
+			copy(entries, t.entries[:pos])
+			copy(entries[pos:], t.entries[pos+1:])
+			newBranch := &branch[K, V]{bitmap: t.bitmap &^ bit, entries: entries, count: t.count - 1}
+			// a branch with a single remaining leaf collapses into that
+			// leaf, keeping the trie from accumulating single-child chains
+			if len(entries) == 1 {
+				if leaf, ok := entries[0].(entryLeaf[K, V]); ok {
+					return leaf, true
+				}
+			}
+			return newBranch, true
+		}
+		if len(t.entries) == 1 {
+			if leaf, ok := child.(entryLeaf[K, V]); ok {
+				return leaf, true
+			}
+		}
+
+		entries := append([]mapNode[K, V]{}, t.entries...)
+		entries[pos] = child
+		return &branch[K, V]{bitmap: t.bitmap, entries: entries, count: t.count - 1}, true
 	}
-	return count
+	panic("ps: unreachable mapNode kind")
 }
 
-func (m *tree) Lookup(key string) (interface{}, bool) {
-	hash := hashKey(key)
-	return lookupLowLevel(m, hash, hash)
+func (h *hamtMap[K, V]) Lookup(key K) (V, bool) {
+	hash := hashAny(key)
+	return lookupNode[K, V](h.root, hash, 0, key)
 }
 
-func lookupLowLevel(self *tree, partialHash, hash uint64) (interface{}, bool) {
-	if self.IsNil() { // an empty tree is easy
-		return nil, false
-	}
+func lookupNode[K comparable, V any](n mapNode[K, V], hash uint64, level int, key K) (V, bool) {
+	switch t := n.(type) {
+	case nil:
+		var zero V
+		return zero, false
 
-	if hash != self.hash {
-		i := partialHash % childCount
-		return lookupLowLevel(self.children[i], partialHash>>shiftSize, hash)
+	case entryLeaf[K, V]:
+		if t.key == key {
+			return t.value, true
+		}
+		var zero V
+		return zero, false
+
+	case *collisionNode[K, V]:
+		for _, p := range t.pairs {
+			if p.key == key {
+				return p.value, true
+			}
+		}
+		var zero V
+		return zero, false
+
+	case *branch[K, V]:
+		idx := hashChunk(hash, level)
+		bit := uint32(1) << idx
+		if t.bitmap&bit == 0 {
+			var zero V
+			return zero, false
+		}
+		pos := bits.OnesCount32(t.bitmap & (bit - 1))
+		return lookupNode[K, V](t.entries[pos], hash, level+1, key)
 	}
-
-	// we found it
-	return self.value, true
+	var zero V
+	return zero, false
 }
 
-func (m *tree) First() interface{} {
-	return m.value
+// First returns the "first" value in the map, if any, or the zero value
+// of V. The notion of "first" is whatever leaf is cheapest to reach, not
+// any particular ordering.
+func (h *hamtMap[K, V]) First() V {
+	v, _ := firstNode[K, V](h.root)
+	return v
 }
 
-func (m *tree) Size() int {
-	return m.count
+func firstNode[K comparable, V any](n mapNode[K, V]) (V, bool) {
+	switch t := n.(type) {
+	case nil:
+		var zero V
+		return zero, false
+	case entryLeaf[K, V]:
+		return t.value, true
+	case *collisionNode[K, V]:
+		return t.pairs[0].value, true
+	case *branch[K, V]:
+		return firstNode[K, V](t.entries[0])
+	}
+	var zero V
+	return zero, false
 }
 
-func (m *tree) ForEach(f func(key string, val interface{})) {
-	if m.IsNil() {
-		return
+func (h *hamtMap[K, V]) Size() int {
+	if h.root == nil {
+		return 0
 	}
+	return h.root.size()
+}
 
-	// ourself
-	f(m.key, m.value)
+func (h *hamtMap[K, V]) ForEach(f func(key K, val V)) {
+	forEachNode[K, V](h.root, f)
+}
 
-	// children
-	for _, t := range m.children {
-		if t != nilMap {
-			t.ForEach(f)
+func forEachNode[K comparable, V any](n mapNode[K, V], f func(key K, val V)) {
+	switch t := n.(type) {
+	case nil:
+		return
+	case entryLeaf[K, V]:
+		f(t.key, t.value)
+	case *collisionNode[K, V]:
+		for _, p := range t.pairs {
+			f(p.key, p.value)
+		}
+	case *branch[K, V]:
+		for _, child := range t.entries {
+			forEachNode[K, V](child, f)
 		}
 	}
 }
 
-func (m *tree) Keys() []string {
-	keys := make([]string, m.Size())
-	i := 0
-	m.ForEach(func(k string, v interface{}) {
-		keys[i] = k
-		i++
+func (h *hamtMap[K, V]) Keys() []K {
+	keys := make([]K, 0, h.Size())
+	h.ForEach(func(k K, v V) {
+		keys = append(keys, k)
 	})
 	return keys
 }
 
 // make it easier to display maps for debugging
-func (m *tree) String() string {
-	keys := m.Keys()
+func (h *hamtMap[K, V]) String() string {
 	buf := bytes.NewBufferString("{")
-	for _, key := range keys {
-		val, _ := m.Lookup(key)
-		fmt.Fprintf(buf, "%s: %s, ", key, val)
-	}
+	h.ForEach(func(key K, val V) {
+		fmt.Fprintf(buf, "%v: %v, ", key, val)
+	})
 	fmt.Fprintf(buf, "}\n")
 	return buf.String()
 }
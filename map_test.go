@@ -0,0 +1,169 @@
+package ps
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMapSetLookupDelete(t *testing.T) {
+	m := NewMap[string, int]()
+	if !m.IsNil() {
+		t.Fatal("new map should be empty")
+	}
+
+	m = m.Set("a", 1).Set("b", 2).Set("c", 3)
+	if got := m.Size(); got != 3 {
+		t.Fatalf("Size() = %d, want 3", got)
+	}
+	if v, ok := m.Lookup("b"); !ok || v != 2 {
+		t.Fatalf("Lookup(b) = %v, %v, want 2, true", v, ok)
+	}
+	if _, ok := m.Lookup("z"); ok {
+		t.Fatal("Lookup(z) found a key that was never set")
+	}
+
+	m2 := m.Delete("b")
+	if got := m2.Size(); got != 2 {
+		t.Fatalf("after Delete, Size() = %d, want 2", got)
+	}
+	if _, ok := m2.Lookup("b"); ok {
+		t.Fatal("Lookup(b) found a key that was deleted")
+	}
+	if got := m.Size(); got != 3 {
+		t.Fatalf("Delete mutated the receiver: Size() = %d, want 3", got)
+	}
+
+	if same := m.Delete("nonexistent"); same != m {
+		t.Fatal("Delete of a missing key should return the receiver unchanged")
+	}
+}
+
+func TestMapRandomizedWorkload(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 5000
+
+	m := NewMap[int, int]()
+	model := make(map[int]int)
+
+	for i := 0; i < n; i++ {
+		k := rng.Intn(n / 2)
+		v := rng.Int()
+		m = m.Set(k, v)
+		model[k] = v
+	}
+
+	for k, want := range model {
+		got, ok := m.Lookup(k)
+		if !ok || got != want {
+			t.Fatalf("Lookup(%d) = %v, %v, want %v, true", k, got, ok, want)
+		}
+	}
+	if got := m.Size(); got != len(model) {
+		t.Fatalf("Size() = %d, want %d", got, len(model))
+	}
+
+	for k := range model {
+		if rng.Intn(2) == 0 {
+			m = m.Delete(k)
+			delete(model, k)
+		}
+	}
+	if got := m.Size(); got != len(model) {
+		t.Fatalf("after deletes, Size() = %d, want %d", got, len(model))
+	}
+	for k, want := range model {
+		got, ok := m.Lookup(k)
+		if !ok || got != want {
+			t.Fatalf("after deletes, Lookup(%d) = %v, %v, want %v, true", k, got, ok, want)
+		}
+	}
+}
+
+// TestMapHashCollision exercises the collisionNode fallback directly,
+// bypassing hashAny so two distinct keys are forced to share a hash the
+// way a genuine 64-bit collision would: mergeLeaves only produces a
+// collisionNode once level has reached maxLevel and there are no more
+// hash bits left to branch on.
+func TestMapHashCollision(t *testing.T) {
+	const hash = 42
+	a := entryLeaf[string, int]{hash: hash, key: "a", value: 1}
+	b := entryLeaf[string, int]{hash: hash, key: "b", value: 2}
+
+	var root mapNode[string, int] = mergeLeaves(maxLevel, a, b)
+	c, ok := root.(*collisionNode[string, int])
+	if !ok {
+		t.Fatalf("root = %T, want *collisionNode", root)
+	}
+	if len(c.pairs) != 2 {
+		t.Fatalf("collisionNode has %d pairs, want 2", len(c.pairs))
+	}
+
+	if v, ok := lookupNode[string, int](root, hash, maxLevel, "a"); !ok || v != 1 {
+		t.Fatalf("lookup a = %v, %v, want 1, true", v, ok)
+	}
+	if v, ok := lookupNode[string, int](root, hash, maxLevel, "b"); !ok || v != 2 {
+		t.Fatalf("lookup b = %v, %v, want 2, true", v, ok)
+	}
+
+	root, deleted := deleteNode[string, int](root, hash, maxLevel, "a")
+	if !deleted {
+		t.Fatal("delete a should report deleted")
+	}
+	if leaf, ok := root.(entryLeaf[string, int]); !ok || leaf.key != "b" {
+		t.Fatalf("after deleting down to one pair, root = %#v, want entryLeaf{key: b}", root)
+	}
+}
+
+func TestMapSetFuncEquatable(t *testing.T) {
+	m := NewMap[string, eqValue]().Set("a", eqValue(1))
+	same := m.SetFunc("a", eqValue(1), nil)
+	if same != m {
+		t.Fatal("SetFunc with an Equatable equal value should return the receiver unchanged")
+	}
+	changed := m.SetFunc("a", eqValue(2), nil)
+	if changed == m {
+		t.Fatal("SetFunc with a different Equatable value should return a new map")
+	}
+	if v, _ := changed.Lookup("a"); v != eqValue(2) {
+		t.Fatalf("Lookup(a) = %v, want 2", v)
+	}
+}
+
+func TestMapSetFuncDeepEqualFallback(t *testing.T) {
+	type point struct{ X, Y int }
+	m := NewMap[string, point]().Set("p", point{1, 2})
+	same := m.SetFunc("p", point{1, 2}, nil)
+	if same != m {
+		t.Fatal("SetFunc with a deeply-equal non-Equatable value should return the receiver unchanged")
+	}
+}
+
+func TestMapUnsafeMutableSet(t *testing.T) {
+	m := NewMap[string, int]().Set("a", 1)
+	same := m.UnsafeMutableSet("a", 2)
+	if same != m {
+		t.Fatal("UnsafeMutableSet should return the same map instance")
+	}
+	if v, _ := m.Lookup("a"); v != 2 {
+		t.Fatalf("Lookup(a) = %v after UnsafeMutableSet, want 2", v)
+	}
+}
+
+func TestMapForEachAndKeys(t *testing.T) {
+	m := NewMap[string, int]().Set("a", 1).Set("b", 2).Set("c", 3)
+
+	seen := make(map[string]int)
+	m.ForEach(func(k string, v int) { seen[k] = v })
+	if len(seen) != 3 || seen["a"] != 1 || seen["b"] != 2 || seen["c"] != 3 {
+		t.Fatalf("ForEach visited %v, want a:1 b:2 c:3", seen)
+	}
+
+	keys := m.Keys()
+	if len(keys) != 3 {
+		t.Fatalf("Keys() = %v, want 3 keys", keys)
+	}
+}
+
+type eqValue int
+
+func (a eqValue) Equal(b eqValue) bool { return a == b }
@@ -0,0 +1,319 @@
+package ps
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// OrderedMap associates unique string keys with values of type V, like
+// Map[string, V], but walks and iterates in key order rather than hash
+// order. That ordering is what makes Range, WalkPrefix and LongestPrefix
+// possible - querying a Map for "everything between lo and hi" or "every
+// URI under this prefix" otherwise means scanning the whole thing.
+type OrderedMap[V any] interface {
+	// IsNil returns true if the OrderedMap is empty
+	IsNil() bool
+
+	// Set returns a new map in which key and value are associated.
+	// This operation is O(log N) in the number of keys.
+	Set(key string, value V) OrderedMap[V]
+
+	// Delete returns a new map with the association for key, if any,
+	// removed. This operation is O(log N) in the number of keys.
+	Delete(key string) OrderedMap[V]
+
+	// Lookup returns the value associated with a key, if any.
+	// This operation is O(log N) in the number of keys.
+	Lookup(key string) (V, bool)
+
+	// Size returns the number of key value pairs in the map.
+	Size() int
+
+	// ForEach executes a callback on each key value pair in the map, in
+	// ascending key order.
+	ForEach(f func(key string, val V))
+
+	// Keys returns a slice with all keys in this map, in ascending order.
+	Keys() []string
+
+	// Range calls f for every key in [lo, hi), in ascending order, until
+	// f returns false or the range is exhausted. This operation is
+	// O(log N + k) for a range matching k keys.
+	Range(lo, hi string, f func(key string, val V) bool)
+
+	// WalkPrefix calls f for every key having prefix, in ascending
+	// order, until f returns false or there are no more matches.
+	WalkPrefix(prefix string, f func(key string, val V) bool)
+
+	// LongestPrefix returns the longest key in the map that is a prefix
+	// of key, if any.
+	LongestPrefix(key string) (string, V, bool)
+
+	String() string
+}
+
+// orderedMap is a persistent treap keyed by string, following gopls's
+// internal/persistent map: a balanced binary search tree whose shape is
+// randomized by giving each node a priority (derived here from hashKey, so
+// the tree stays purely a function of its keys rather than threading an
+// RNG through every insert) and maintaining heap order on priority via
+// rotations. BST order on the key gives ordered iteration and range
+// queries for free.
+type orderedMap[V any] struct {
+	root *treapNode[V]
+}
+
+// NewOrderedMap allocates a new, persistent map from strings to values of
+// type V with ordered iteration and range queries.
+func NewOrderedMap[V any]() OrderedMap[V] {
+	return &orderedMap[V]{}
+}
+
+type treapNode[V any] struct {
+	key         string
+	value       V
+	priority    uint64
+	left, right *treapNode[V]
+	size        int
+}
+
+func treapSize[V any](n *treapNode[V]) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func newTreapNode[V any](key string, value V, left, right *treapNode[V]) *treapNode[V] {
+	return &treapNode[V]{
+		key:      key,
+		value:    value,
+		priority: hashKey(key),
+		left:     left,
+		right:    right,
+		size:     treapSize(left) + treapSize(right) + 1,
+	}
+}
+
+// rotateRight and rotateLeft restore heap order on priority after an
+// insert pushes a higher-priority node below its parent. Both return a
+// new node; the rotated-away node is never mutated.
+func rotateRight[V any](y *treapNode[V]) *treapNode[V] {
+	x := y.left
+	newY := newTreapNode(y.key, y.value, x.right, y.right)
+	return newTreapNode(x.key, x.value, x.left, newY)
+}
+
+func rotateLeft[V any](x *treapNode[V]) *treapNode[V] {
+	y := x.right
+	newX := newTreapNode(x.key, x.value, x.left, y.left)
+	return newTreapNode(y.key, y.value, newX, y.right)
+}
+
+func treapInsert[V any](n *treapNode[V], key string, value V) *treapNode[V] {
+	if n == nil {
+		return newTreapNode(key, value, nil, nil)
+	}
+	switch {
+	case key < n.key:
+		left := treapInsert(n.left, key, value)
+		newN := newTreapNode(n.key, n.value, left, n.right)
+		if left.priority > newN.priority {
+			newN = rotateRight(newN)
+		}
+		return newN
+	case key > n.key:
+		right := treapInsert(n.right, key, value)
+		newN := newTreapNode(n.key, n.value, n.left, right)
+		if right.priority > newN.priority {
+			newN = rotateLeft(newN)
+		}
+		return newN
+	default:
+		return newTreapNode(key, value, n.left, n.right)
+	}
+}
+
+func treapDelete[V any](n *treapNode[V], key string) *treapNode[V] {
+	if n == nil {
+		return nil
+	}
+	switch {
+	case key < n.key:
+		return newTreapNode(n.key, n.value, treapDelete(n.left, key), n.right)
+	case key > n.key:
+		return newTreapNode(n.key, n.value, n.left, treapDelete(n.right, key))
+	default:
+		return treapMerge(n.left, n.right)
+	}
+}
+
+// treapMerge joins two subtrees known to span disjoint key ranges
+// (left's keys all sort before right's), preserving heap order.
+func treapMerge[V any](left, right *treapNode[V]) *treapNode[V] {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	if left.priority > right.priority {
+		return newTreapNode(left.key, left.value, left.left, treapMerge(left.right, right))
+	}
+	return newTreapNode(right.key, right.value, treapMerge(left, right.left), right.right)
+}
+
+func treapLookup[V any](n *treapNode[V], key string) (V, bool) {
+	for n != nil {
+		switch {
+		case key < n.key:
+			n = n.left
+		case key > n.key:
+			n = n.right
+		default:
+			return n.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// treapForEach performs an in-order (ascending key) walk, stopping early
+// if f returns false. Its own return value says whether the walk ran to
+// completion, so callers higher up the recursion know whether to continue.
+func treapForEach[V any](n *treapNode[V], f func(string, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if !treapForEach(n.left, f) {
+		return false
+	}
+	if !f(n.key, n.value) {
+		return false
+	}
+	return treapForEach(n.right, f)
+}
+
+// treapRange is treapForEach restricted to [lo, hi), pruning subtrees
+// that fall entirely outside the range instead of visiting and filtering
+// every node.
+func treapRange[V any](n *treapNode[V], lo, hi string, f func(string, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.key > lo {
+		if !treapRange(n.left, lo, hi, f) {
+			return false
+		}
+	}
+	if n.key >= lo && n.key < hi {
+		if !f(n.key, n.value) {
+			return false
+		}
+	}
+	if n.key < hi {
+		if !treapRange(n.right, lo, hi, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixUpperBound returns the smallest string that sorts after every
+// string with the given prefix, so that [prefix, upperBound) is exactly
+// the set of keys having that prefix. It reports false only when prefix
+// is empty or made entirely of 0xff bytes, i.e. no such bound exists.
+func prefixUpperBound(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] != 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+func treapWalkPrefix[V any](n *treapNode[V], prefix string, f func(string, V) bool) bool {
+	matching := func(k string, v V) bool {
+		if !strings.HasPrefix(k, prefix) {
+			return true
+		}
+		return f(k, v)
+	}
+	if hi, ok := prefixUpperBound(prefix); ok {
+		return treapRange(n, prefix, hi, matching)
+	}
+	// prefix has no upper bound (e.g. all 0xff bytes, or empty): fall
+	// back to a full walk rather than special-casing an open range.
+	return treapForEach(n, matching)
+}
+
+// treapLongestPrefix tries successively shorter prefixes of key against
+// the map, returning the first (longest) one present.
+func treapLongestPrefix[V any](n *treapNode[V], key string) (string, V, bool) {
+	for i := len(key); i >= 0; i-- {
+		if v, ok := treapLookup(n, key[:i]); ok {
+			return key[:i], v, true
+		}
+	}
+	var zero V
+	return "", zero, false
+}
+
+func (m *orderedMap[V]) IsNil() bool {
+	return m.root == nil
+}
+
+func (m *orderedMap[V]) Set(key string, value V) OrderedMap[V] {
+	return &orderedMap[V]{root: treapInsert(m.root, key, value)}
+}
+
+func (m *orderedMap[V]) Delete(key string) OrderedMap[V] {
+	return &orderedMap[V]{root: treapDelete(m.root, key)}
+}
+
+func (m *orderedMap[V]) Lookup(key string) (V, bool) {
+	return treapLookup(m.root, key)
+}
+
+func (m *orderedMap[V]) Size() int {
+	return treapSize(m.root)
+}
+
+func (m *orderedMap[V]) ForEach(f func(key string, val V)) {
+	treapForEach(m.root, func(k string, v V) bool {
+		f(k, v)
+		return true
+	})
+}
+
+func (m *orderedMap[V]) Keys() []string {
+	keys := make([]string, 0, m.Size())
+	m.ForEach(func(k string, v V) {
+		keys = append(keys, k)
+	})
+	return keys
+}
+
+func (m *orderedMap[V]) Range(lo, hi string, f func(key string, val V) bool) {
+	treapRange(m.root, lo, hi, f)
+}
+
+func (m *orderedMap[V]) WalkPrefix(prefix string, f func(key string, val V) bool) {
+	treapWalkPrefix(m.root, prefix, f)
+}
+
+func (m *orderedMap[V]) LongestPrefix(key string) (string, V, bool) {
+	return treapLongestPrefix(m.root, key)
+}
+
+func (m *orderedMap[V]) String() string {
+	buf := bytes.NewBufferString("{")
+	m.ForEach(func(key string, val V) {
+		fmt.Fprintf(buf, "%s: %v, ", key, val)
+	})
+	fmt.Fprintf(buf, "}\n")
+	return buf.String()
+}
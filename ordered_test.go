@@ -0,0 +1,187 @@
+package ps
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestOrderedMapSetLookupDelete(t *testing.T) {
+	m := NewOrderedMap[int]()
+	if !m.IsNil() {
+		t.Fatal("new map should be empty")
+	}
+
+	m = m.Set("b", 2).Set("a", 1).Set("c", 3)
+	if got := m.Size(); got != 3 {
+		t.Fatalf("Size() = %d, want 3", got)
+	}
+	if v, ok := m.Lookup("a"); !ok || v != 1 {
+		t.Fatalf("Lookup(a) = %v, %v, want 1, true", v, ok)
+	}
+
+	m2 := m.Delete("a")
+	if got := m2.Size(); got != 2 {
+		t.Fatalf("after Delete, Size() = %d, want 2", got)
+	}
+	if _, ok := m2.Lookup("a"); ok {
+		t.Fatal("Lookup(a) found a key that was deleted")
+	}
+	if got := m.Size(); got != 3 {
+		t.Fatalf("Delete mutated the receiver: Size() = %d, want 3", got)
+	}
+}
+
+// TestOrderedMapIterationOrder checks that ForEach and Keys visit keys in
+// ascending order regardless of insertion order, which is the entire
+// point of the treap over a plain hash trie.
+func TestOrderedMapIterationOrder(t *testing.T) {
+	keys := []string{"delta", "alpha", "charlie", "echo", "bravo"}
+	m := NewOrderedMap[int]()
+	for i, k := range keys {
+		m = m.Set(k, i)
+	}
+
+	var got []string
+	m.ForEach(func(k string, v int) { got = append(got, k) })
+
+	want := append([]string{}, keys...)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("ForEach visited %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ForEach order = %v, want %v", got, want)
+		}
+	}
+
+	gotKeys := m.Keys()
+	for i := range want {
+		if gotKeys[i] != want[i] {
+			t.Fatalf("Keys() = %v, want %v", gotKeys, want)
+		}
+	}
+}
+
+// TestOrderedMapRandomizedInvariant inserts and deletes random keys and
+// checks, once all operations are done, that the treap reports exactly the
+// model's keys in sorted order - the BST invariant Range/WalkPrefix depend on.
+func TestOrderedMapRandomizedInvariant(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	const alphabet = "abcde"
+	const n = 2000
+
+	m := NewOrderedMap[int]()
+	model := make(map[string]int)
+
+	randKey := func() string {
+		b := make([]byte, 1+rng.Intn(3))
+		for i := range b {
+			b[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return string(b)
+	}
+
+	for i := 0; i < n; i++ {
+		k := randKey()
+		if rng.Intn(4) == 0 && len(model) > 0 {
+			m = m.Delete(k)
+			delete(model, k)
+			continue
+		}
+		v := rng.Int()
+		m = m.Set(k, v)
+		model[k] = v
+	}
+
+	if got := m.Size(); got != len(model) {
+		t.Fatalf("Size() = %d, want %d", got, len(model))
+	}
+
+	var want []string
+	for k := range model {
+		want = append(want, k)
+	}
+	sort.Strings(want)
+
+	var got []string
+	m.ForEach(func(k string, v int) {
+		got = append(got, k)
+		if mv := model[k]; v != mv {
+			t.Fatalf("ForEach value for %q = %d, want %d", k, v, mv)
+		}
+	})
+	if len(got) != len(want) {
+		t.Fatalf("ForEach visited %d keys, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ForEach out of order at %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestOrderedMapRange(t *testing.T) {
+	m := NewOrderedMap[int]()
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		m = m.Set(k, i)
+	}
+
+	var got []string
+	m.Range("b", "d", func(k string, v int) bool {
+		got = append(got, k)
+		return true
+	})
+	want := []string{"b", "c"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Range(b, d) = %v, want %v", got, want)
+	}
+
+	var stopped []string
+	m.Range("a", "e", func(k string, v int) bool {
+		stopped = append(stopped, k)
+		return k != "b"
+	})
+	if len(stopped) != 2 {
+		t.Fatalf("Range should stop once f returns false: got %v", stopped)
+	}
+}
+
+func TestOrderedMapWalkPrefix(t *testing.T) {
+	m := NewOrderedMap[int]()
+	for i, k := range []string{"app", "apple", "application", "banana"} {
+		m = m.Set(k, i)
+	}
+
+	var got []string
+	m.WalkPrefix("app", func(k string, v int) bool {
+		got = append(got, k)
+		return true
+	})
+	want := []string{"app", "apple", "application"}
+	if len(got) != len(want) {
+		t.Fatalf("WalkPrefix(app) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("WalkPrefix(app) = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestOrderedMapLongestPrefix(t *testing.T) {
+	m := NewOrderedMap[int]()
+	for i, k := range []string{"a", "ap", "app", "apple"} {
+		m = m.Set(k, i)
+	}
+
+	k, v, ok := m.LongestPrefix("application")
+	if !ok || k != "app" {
+		t.Fatalf("LongestPrefix(application) = %q, %v, %v, want app, _, true", k, v, ok)
+	}
+
+	if _, _, ok := m.LongestPrefix("banana"); ok {
+		t.Fatal("LongestPrefix(banana) should report no match")
+	}
+}
@@ -0,0 +1,300 @@
+package ps
+
+import (
+	"container/list"
+	"math/bits"
+)
+
+// defaultModCacheSize bounds how many branch nodes a Txn will remember as
+// "already cloned for this transaction" before evicting the oldest. A
+// node evicted from the cache is treated as foreign again and copy-on-write
+// applies to it on the next mutation, so long transactions can't pin the
+// entire mutated spine in memory.
+const defaultModCacheSize = 8192
+
+// Txn batches many mutations against a Map into a single pending trie,
+// reusing freshly-cloned branch nodes in place across calls (safe because
+// they aren't reachable from any published Map yet), then publishes an
+// immutable Map via Commit. This avoids allocating a fresh spine on every
+// Insert the way repeated calls to Set would.
+type Txn[K comparable, V any] struct {
+	root        mapNode[K, V]
+	size        int
+	writable    *nodeCache[K, V]
+	trackMutate bool
+	mutations   map[K]struct{}
+}
+
+// Txn starts a transaction for batching many mutations before they're
+// published as a new, immutable Map via Commit.
+func (h *hamtMap[K, V]) Txn() *Txn[K, V] {
+	return &Txn[K, V]{
+		root:     h.root,
+		size:     h.Size(),
+		writable: newNodeCache[K, V](defaultModCacheSize),
+	}
+}
+
+// TrackMutate controls whether the transaction records the set of keys
+// whose values changed, retrievable afterwards with Mutations. Off by
+// default since tracking costs a map insert per mutation.
+func (txn *Txn[K, V]) TrackMutate(track bool) {
+	txn.trackMutate = track
+}
+
+// Mutations returns the keys deleted, or inserted with a value not equal to
+// the one already stored, since TrackMutate(true) was called, letting callers broadcast targeted invalidations after
+// Commit instead of diffing the whole map.
+func (txn *Txn[K, V]) Mutations() []K {
+	keys := make([]K, 0, len(txn.mutations))
+	for k := range txn.mutations {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Insert associates key with value in the transaction.
+func (txn *Txn[K, V]) Insert(key K, value V) {
+	hash := hashAny(key)
+	unchanged := false
+	if txn.trackMutate {
+		old, ok := lookupNode[K, V](txn.root, hash, 0, key)
+		unchanged = ok && valuesEqual(old, value, nil)
+	}
+	root, added := txn.setNode(txn.root, hash, 0, key, value)
+	txn.root = root
+	if added {
+		txn.size++
+	}
+	if !unchanged {
+		txn.recordMutation(key)
+	}
+}
+
+// Delete removes key from the transaction, reporting whether it was
+// present.
+func (txn *Txn[K, V]) Delete(key K) bool {
+	hash := hashAny(key)
+	root, deleted := txn.deleteNode(txn.root, hash, 0, key)
+	if !deleted {
+		return false
+	}
+	txn.root = root
+	txn.size--
+	txn.recordMutation(key)
+	return true
+}
+
+// Get returns the value associated with key, if any, as it stands in the
+// transaction so far.
+func (txn *Txn[K, V]) Get(key K) (V, bool) {
+	hash := hashAny(key)
+	return lookupNode[K, V](txn.root, hash, 0, key)
+}
+
+// Len returns the number of key/value pairs in the transaction so far.
+func (txn *Txn[K, V]) Len() int {
+	return txn.size
+}
+
+// Commit materializes the transaction's pending mutations into a new,
+// immutable Map. The transaction's writable-node cache is reset so that
+// any further calls made against this Txn clone-on-write again, rather
+// than mutating nodes now reachable from the committed Map.
+func (txn *Txn[K, V]) Commit() Map[K, V] {
+	m := &hamtMap[K, V]{root: txn.root}
+	txn.writable = newNodeCache[K, V](defaultModCacheSize)
+	return m
+}
+
+func (txn *Txn[K, V]) recordMutation(key K) {
+	if !txn.trackMutate {
+		return
+	}
+	if txn.mutations == nil {
+		txn.mutations = make(map[K]struct{})
+	}
+	txn.mutations[key] = struct{}{}
+}
+
+// writableBranch returns a branch node the transaction may mutate
+// in-place: b itself if this transaction already cloned it, otherwise a
+// fresh clone that's claimed for the rest of the transaction's lifetime
+// (or until evicted from the bounded cache).
+func (txn *Txn[K, V]) writableBranch(b *branch[K, V]) *branch[K, V] {
+	if txn.writable.owns(b) {
+		return b
+	}
+	clone := &branch[K, V]{
+		bitmap:  b.bitmap,
+		entries: append([]mapNode[K, V]{}, b.entries...),
+		count:   b.count,
+	}
+	txn.writable.claim(clone)
+	return clone
+}
+
+func (txn *Txn[K, V]) setNode(n mapNode[K, V], hash uint64, level int, key K, value V) (mapNode[K, V], bool) {
+	switch t := n.(type) {
+	case nil:
+		return entryLeaf[K, V]{hash: hash, key: key, value: value}, true
+
+	case entryLeaf[K, V]:
+		if t.key == key {
+			return entryLeaf[K, V]{hash: hash, key: key, value: value}, false
+		}
+		return mergeLeaves(level, t, entryLeaf[K, V]{hash: hash, key: key, value: value}), true
+
+	case *collisionNode[K, V]:
+		pairs := append([]entryLeaf[K, V]{}, t.pairs...)
+		for i, p := range pairs {
+			if p.key == key {
+				pairs[i] = entryLeaf[K, V]{hash: hash, key: key, value: value}
+				return &collisionNode[K, V]{hash: t.hash, pairs: pairs}, false
+			}
+		}
+		pairs = append(pairs, entryLeaf[K, V]{hash: hash, key: key, value: value})
+		return &collisionNode[K, V]{hash: t.hash, pairs: pairs}, true
+
+	case *branch[K, V]:
+		b := txn.writableBranch(t)
+		idx := hashChunk(hash, level)
+		bit := uint32(1) << idx
+		pos := bits.OnesCount32(b.bitmap & (bit - 1))
+
+		if b.bitmap&bit == 0 {
+			entries := make([]mapNode[K, V], len(b.entries)+1)
+			copy(entries, b.entries[:pos])
+			entries[pos] = entryLeaf[K, V]{hash: hash, key: key, value: value}
+			copy(entries[pos+1:], b.entries[pos:])
+			b.bitmap |= bit
+			b.entries = entries
+			b.count++
+			return b, true
+		}
+
+		child, added := txn.setNode(b.entries[pos], hash, level+1, key, value)
+		b.entries[pos] = child
+		if added {
+			b.count++
+		}
+		return b, added
+	}
+	panic("ps: unreachable mapNode kind")
+}
+
+func (txn *Txn[K, V]) deleteNode(n mapNode[K, V], hash uint64, level int, key K) (mapNode[K, V], bool) {
+	switch t := n.(type) {
+	case nil:
+		return nil, false
+
+	case entryLeaf[K, V]:
+		if t.key != key {
+			return t, false
+		}
+		return nil, true
+
+	case *collisionNode[K, V]:
+		for i, p := range t.pairs {
+			if p.key != key {
+				continue
+			}
+			pairs := append(append([]entryLeaf[K, V]{}, t.pairs[:i]...), t.pairs[i+1:]...)
+			if len(pairs) == 1 {
+				return pairs[0], true
+			}
+			return &collisionNode[K, V]{hash: t.hash, pairs: pairs}, true
+		}
+		return t, false
+
+	case *branch[K, V]:
+		idx := hashChunk(hash, level)
+		bit := uint32(1) << idx
+		if t.bitmap&bit == 0 {
+			return t, false
+		}
+		b := txn.writableBranch(t)
+		pos := bits.OnesCount32(b.bitmap & (bit - 1))
+
+		child, deleted := txn.deleteNode(b.entries[pos], hash, level+1, key)
+		if !deleted {
+			return b, false
+		}
+
+		if child == nil {
+			if b.count == 1 {
+				return nil, true
+			}
+			entries := make([]mapNode[K, V], len(b.entries)-1)
+			copy(entries, b.entries[:pos])
+			copy(entries[pos:], b.entries[pos+1:])
+			b.bitmap &^= bit
+			b.entries = entries
+			b.count--
+			if len(entries) == 1 {
+				if leaf, ok := entries[0].(entryLeaf[K, V]); ok {
+					return leaf, true
+				}
+			}
+			return b, true
+		}
+		if len(b.entries) == 1 {
+			if leaf, ok := child.(entryLeaf[K, V]); ok {
+				return leaf, true
+			}
+		}
+
+		b.entries[pos] = child
+		b.count--
+		return b, true
+	}
+	panic("ps: unreachable mapNode kind")
+}
+
+// nodeCache is a bounded LRU set of branch nodes a Txn has already cloned
+// for its own exclusive use. Keying on the node's pointer, rather than its
+// contents, is what lets it work regardless of whether V is comparable.
+type nodeCache[K comparable, V any] struct {
+	cap   int
+	ll    *list.List
+	items map[*branch[K, V]]*list.Element
+}
+
+func newNodeCache[K comparable, V any](capacity int) *nodeCache[K, V] {
+	return &nodeCache[K, V]{
+		cap:   capacity,
+		ll:    list.New(),
+		items: make(map[*branch[K, V]]*list.Element),
+	}
+}
+
+// owns reports whether b was already claimed by this cache, refreshing
+// its recency if so.
+func (c *nodeCache[K, V]) owns(b *branch[K, V]) bool {
+	el, ok := c.items[b]
+	if !ok {
+		return false
+	}
+	c.ll.MoveToFront(el)
+	return true
+}
+
+// claim records b as writable, evicting the least-recently-used node
+// if that pushes the cache past its capacity.
+func (c *nodeCache[K, V]) claim(b *branch[K, V]) {
+	if el, ok := c.items[b]; ok {
+		c.ll.MoveToFront(el)
+		return
+	}
+	el := c.ll.PushFront(b)
+	c.items[b] = el
+	if c.ll.Len() <= c.cap {
+		return
+	}
+	oldest := c.ll.Back()
+	if oldest == nil {
+		return
+	}
+	c.ll.Remove(oldest)
+	delete(c.items, oldest.Value.(*branch[K, V]))
+}
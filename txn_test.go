@@ -0,0 +1,102 @@
+package ps
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestTxnInsertDeleteCommit(t *testing.T) {
+	base := NewMap[string, int]().Set("a", 1).Set("b", 2)
+
+	txn := base.Txn()
+	txn.Insert("c", 3)
+	txn.Insert("a", 10)
+	if !txn.Delete("b") {
+		t.Fatal("Delete(b) should report b was present")
+	}
+	if txn.Delete("missing") {
+		t.Fatal("Delete(missing) should report false")
+	}
+
+	if v, ok := txn.Get("a"); !ok || v != 10 {
+		t.Fatalf("Get(a) = %v, %v, want 10, true", v, ok)
+	}
+	if _, ok := txn.Get("b"); ok {
+		t.Fatal("Get(b) should report absent after Delete")
+	}
+	if got := txn.Len(); got != 2 {
+		t.Fatalf("Len() = %d, want 2", got)
+	}
+
+	committed := txn.Commit()
+	if got := committed.Size(); got != 2 {
+		t.Fatalf("committed.Size() = %d, want 2", got)
+	}
+	if v, ok := committed.Lookup("a"); !ok || v != 10 {
+		t.Fatalf("committed.Lookup(a) = %v, %v, want 10, true", v, ok)
+	}
+	if _, ok := committed.Lookup("b"); ok {
+		t.Fatal("committed.Lookup(b) should report absent")
+	}
+
+	// The original map must be untouched by the transaction.
+	if got := base.Size(); got != 2 {
+		t.Fatalf("base.Size() = %d after Txn, want 2 (unchanged)", got)
+	}
+	if v, _ := base.Lookup("a"); v != 1 {
+		t.Fatalf("base.Lookup(a) = %v after Txn, want 1 (unchanged)", v)
+	}
+}
+
+func TestTxnTrackMutate(t *testing.T) {
+	base := NewMap[string, int]().Set("a", 1)
+
+	txn := base.Txn()
+	txn.TrackMutate(true)
+	txn.Insert("b", 2)
+	txn.Insert("c", 3)
+	txn.Delete("a")
+
+	got := txn.Mutations()
+	sort.Strings(got)
+	want := []string{"a", "b", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("Mutations() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Mutations() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTxnTrackMutateDefaultOff(t *testing.T) {
+	txn := NewMap[string, int]().Txn()
+	txn.Insert("a", 1)
+	if got := txn.Mutations(); len(got) != 0 {
+		t.Fatalf("Mutations() = %v, want empty when TrackMutate was never enabled", got)
+	}
+}
+
+func TestTxnRepeatedInsertSameKey(t *testing.T) {
+	txn := NewMap[string, int]().Txn()
+	txn.Insert("a", 1)
+	txn.Insert("a", 2)
+	if got := txn.Len(); got != 1 {
+		t.Fatalf("Len() = %d after inserting the same key twice, want 1", got)
+	}
+	if v, _ := txn.Get("a"); v != 2 {
+		t.Fatalf("Get(a) = %v, want 2 (last write wins)", v)
+	}
+}
+
+func TestTxnCommitIsolatesFurtherMutation(t *testing.T) {
+	txn := NewMap[string, int]().Txn()
+	txn.Insert("a", 1)
+	committed := txn.Commit()
+
+	txn.Insert("b", 2)
+	if _, ok := committed.Lookup("b"); ok {
+		t.Fatal("mutating a Txn after Commit must not affect the already-published Map")
+	}
+}